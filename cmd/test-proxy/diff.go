@@ -1,9 +1,10 @@
 package main
 
 import (
-	"fmt"
+	"bytes"
+	"hash/maphash"
+	"io"
 	"sort"
-	"strings"
 )
 
 type Diff struct {
@@ -12,23 +13,45 @@ type Diff struct {
 }
 
 func DiffMetrics(expected, actual []*Metric) *Diff {
-	keyers := metricKeyers(expected)
-	expectedKeyMap := metricKeyMap(expected, keyers)
-	actualKeyMap := metricKeyMap(actual, keyers)
-	missing, extra := disjunct(expectedKeyMap, actualKeyMap)
-	return &Diff{
-		Missing: missing,
-		Extra:   extra,
-	}
+	return DiffMetricsWithOpts(expected, actual, DiffOptions{})
 }
 
-// keyer returns whether or not it could generate a key and the key of the given metric
-type keyer func(*Metric) (bool, string)
+// keyer writes a canonical, collision-resistant encoding of the fields it
+// cares about into w and reports whether metric matched (i.e. whether it
+// could contribute to a key at all). Composed keyers share a single w so the
+// resulting bytes can be hashed (the hot path, via a *maphash.Hash) or kept
+// around for an exact-equality check on hash collision (the cold path, via a
+// *bytes.Buffer); see canonicalBytes.
+type keyer func(w io.Writer, metric *Metric) bool
+
+// Separator bytes are outside the ASCII range so they can't collide with any
+// name, value or tag byte a user could plausibly write, the same trick used
+// by Prometheus's label signature hashing. fieldSep marks the start of a new
+// field; tagSep marks the split between a tag's name and its value.
+const (
+	fieldSep byte = 0xff
+	tagSep   byte = 0xfe
+)
+
+const (
+	domainName byte = iota + 1
+	domainValue
+	domainTimestamp
+	domainTagName
+	domainTag
+)
 
 func metricKeyers(expected []*Metric) map[string][]keyer {
+	return metricKeyersFor(expected, metricKeyer)
+}
+
+// metricKeyersFor is metricKeyers with the per-metric keyer builder made
+// pluggable, so DiffMetricsWithOpts can key on a Projection instead of the
+// default name+value+timestamp+tags composite.
+func metricKeyersFor(expected []*Metric, keyerFor func(*Metric) keyer) map[string][]keyer {
 	keyersByMetric := map[string][]keyer{}
 	for _, m := range expected {
-		keyersByMetric[m.Name] = append(keyersByMetric[m.Name], metricKeyer(m))
+		keyersByMetric[m.Name] = append(keyersByMetric[m.Name], keyerFor(m))
 	}
 	return keyersByMetric
 }
@@ -47,47 +70,70 @@ func metricKeyer(m *Metric) keyer {
 }
 
 func compositeKey(keyers ...keyer) keyer {
-	return func(metric *Metric) (bool, string) {
-		var keys []string
-		for _, keyer := range keyers {
-			matched, key := keyer(metric)
-			if !matched {
-				return false, ""
+	return func(w io.Writer, metric *Metric) bool {
+		for _, k := range keyers {
+			if !k(w, metric) {
+				return false
 			}
-			keys = append(keys, key)
 		}
-		return true, strings.Join(keys, " ")
+		return true
 	}
 }
 
+func writeField(w io.Writer, domain byte, value string) {
+	w.Write([]byte{fieldSep, domain})
+	io.WriteString(w, value)
+}
+
 func nameKey(expected string) keyer {
-	return func(metric *Metric) (bool, string) {
-		return metric.Name == expected, metric.Name
+	return func(w io.Writer, metric *Metric) bool {
+		if metric.Name != expected {
+			return false
+		}
+		writeField(w, domainName, metric.Name)
+		return true
 	}
 }
 
 func valueKey(expected string) keyer {
-	return func(metric *Metric) (bool, string) {
-		return metric.Value == expected, metric.Value
+	return func(w io.Writer, metric *Metric) bool {
+		if metric.Value != expected {
+			return false
+		}
+		writeField(w, domainValue, metric.Value)
+		return true
 	}
 }
 
 func timestampKey(expected string) keyer {
-	return func(metric *Metric) (bool, string) {
-		return metric.Timestamp == expected, metric.Timestamp
+	return func(w io.Writer, metric *Metric) bool {
+		if metric.Timestamp != expected {
+			return false
+		}
+		writeField(w, domainTimestamp, metric.Timestamp)
+		return true
 	}
 }
 
 func tagNameKey(name string) keyer {
-	return func(metric *Metric) (bool, string) {
-		_, exists := metric.Tags[name]
-		return exists, fmt.Sprintf("%s=*", name)
+	return func(w io.Writer, metric *Metric) bool {
+		if _, exists := metric.Tags[name]; !exists {
+			return false
+		}
+		writeField(w, domainTagName, name)
+		return true
 	}
 }
 
 func fullTagKey(name, value string) keyer {
-	return func(metric *Metric) (bool, string) {
-		return metric.Tags[name] == value, fmt.Sprintf("%s=%#v", name, metric.Tags[name])
+	return func(w io.Writer, metric *Metric) bool {
+		if metric.Tags[name] != value {
+			return false
+		}
+		writeField(w, domainTag, name)
+		w.Write([]byte{tagSep})
+		io.WriteString(w, value)
+		return true
 	}
 }
 
@@ -108,37 +154,96 @@ func tagsKey(tags map[string]string) keyer {
 	return compositeKey(keyers...)
 }
 
-func metricKeyMap(metrics []*Metric, keyers map[string][]keyer) map[string]*Metric {
-	keyMap := map[string]*Metric{}
+// hashSeed is shared by every *maphash.Hash used while diffing so that equal
+// canonical bytes always sum to the same value within a single DiffMetrics
+// call (maphash seeds, and therefore sums, are not meant to be stable across
+// processes, only within one).
+var hashSeed = maphash.MakeSeed()
+
+// canonicalBytes re-runs k against m through a buffer instead of a hash, for
+// the cold path: deciding whether two metrics that hashed to the same bucket
+// are actually the same key or a genuine collision.
+func canonicalBytes(k keyer, m *Metric) []byte {
+	var buf bytes.Buffer
+	k(&buf, m)
+	return buf.Bytes()
+}
+
+// keyBucket retains, for one metric key, the canonical bytes that produced
+// it alongside the metric itself. Buckets are grouped by hash sum, but a
+// sum can hold more than one bucket: on a genuine hash collision between two
+// distinct keys, disjunct tells them apart by comparing canon rather than by
+// which map happened to see which key first (a per-map linear probe would
+// let expected and actual disagree on which colliding key gets which slot).
+type keyBucket struct {
+	metric *Metric
+	canon  []byte
+}
+
+func metricKeyMap(metrics []*Metric, keyers map[string][]keyer) map[uint64][]*keyBucket {
+	buckets := map[uint64][]*keyBucket{}
 	for _, metric := range metrics {
-		foundKeyers := keyers[metric.Name]
-		found := false
-		for _, foundKeyer := range foundKeyers {
-			matched, key := foundKeyer(metric)
-			if matched {
-				keyMap[key] = metric
-				found = true
+		k, sum, ok := keyFor(metric, keyers[metric.Name])
+		if !ok {
+			continue
+		}
+		canon := canonicalBytes(k, metric)
+		list := buckets[sum]
+		replaced := false
+		for _, b := range list {
+			if bytes.Equal(b.canon, canon) {
+				b.metric = metric
+				replaced = true
 				break
 			}
 		}
-		if !found {
-			_, key := metricKeyer(metric)(metric)
-			keyMap[key] = metric
+		if !replaced {
+			buckets[sum] = append(list, &keyBucket{metric: metric, canon: canon})
 		}
 	}
-	return keyMap
+	return buckets
 }
 
-func disjunct(a, b map[string]*Metric) (onlyInA []*Metric, onlyInB []*Metric) {
-	for x := range a {
-		if _, exists := b[x]; !exists {
-			onlyInA = append(onlyInA, a[x])
+// keyFor picks the first of candidates that matches metric, falling back to
+// metric's own composite keyer, and returns its hash sum.
+func keyFor(metric *Metric, candidates []keyer) (k keyer, sum uint64, ok bool) {
+	for _, candidate := range candidates {
+		var h maphash.Hash
+		h.SetSeed(hashSeed)
+		if candidate(&h, metric) {
+			return candidate, h.Sum64(), true
 		}
 	}
-	for y := range b {
-		if _, exists := a[y]; !exists {
-			onlyInB = append(onlyInB, b[y])
+	k = metricKeyer(metric)
+	var h maphash.Hash
+	h.SetSeed(hashSeed)
+	k(&h, metric)
+	return k, h.Sum64(), true
+}
+
+func disjunct(a, b map[uint64][]*keyBucket) (onlyInA []*Metric, onlyInB []*Metric) {
+	for sum, aBuckets := range a {
+		for _, ab := range aBuckets {
+			if findCanon(b[sum], ab.canon) == nil {
+				onlyInA = append(onlyInA, ab.metric)
+			}
+		}
+	}
+	for sum, bBuckets := range b {
+		for _, bb := range bBuckets {
+			if findCanon(a[sum], bb.canon) == nil {
+				onlyInB = append(onlyInB, bb.metric)
+			}
 		}
 	}
 	return onlyInA, onlyInB
 }
+
+func findCanon(buckets []*keyBucket, canon []byte) *keyBucket {
+	for _, b := range buckets {
+		if bytes.Equal(b.canon, canon) {
+			return b
+		}
+	}
+	return nil
+}
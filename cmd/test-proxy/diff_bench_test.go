@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// benchMetrics builds n synthetic kstate-shaped metrics (four tags apiece,
+// three of them unique per metric), standing in for a large integration test
+// fixture, to measure the per-diff allocation cost of DiffMetrics.
+func benchMetrics(n int) []*Metric {
+	metrics := make([]*Metric, n)
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(i)
+		metrics[i] = &Metric{
+			Name:      "kube_pod_status_ready",
+			Value:     "1",
+			Timestamp: "1690000000",
+			Tags: map[string]string{
+				"pod_name":       "pod-" + id,
+				"namespace_name": "default",
+				"uid":            "uid-" + id,
+				"node":           "node-" + id,
+			},
+		}
+	}
+	return metrics
+}
+
+func BenchmarkDiffMetrics(b *testing.B) {
+	expected := benchMetrics(2000)
+	actual := benchMetrics(2000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffMetrics(expected, actual)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DiffOptions controls how DiffMetricsWithOpts selects and compares metrics.
+// The zero value reproduces the behavior of DiffMetrics: every field and tag
+// participates in matching, and no metrics are filtered out beforehand.
+type DiffOptions struct {
+	// Projection, if non-nil, overrides which fields and tags are used to key
+	// metrics for matching. Built with CompileProjection.
+	Projection *Projection
+
+	// ExpectedFilter and ActualFilter, if non-nil, pre-select which expected
+	// and actual metrics participate in the diff at all. Built with
+	// CompileFilter.
+	ExpectedFilter *Filter
+	ActualFilter   *Filter
+}
+
+// DiffMetricsWithOpts is DiffMetrics with optional projection and pre-filtering,
+// for integration tests that only care that a subset of fields/tags line up
+// (e.g. "these kstate metrics must appear with these two tags, values don't matter").
+func DiffMetricsWithOpts(expected, actual []*Metric, opts DiffOptions) *Diff {
+	if opts.ExpectedFilter != nil {
+		expected = filterMetrics(expected, opts.ExpectedFilter)
+	}
+	if opts.ActualFilter != nil {
+		actual = filterMetrics(actual, opts.ActualFilter)
+	}
+
+	keyerFor := metricKeyer
+	if opts.Projection != nil {
+		keyerFor = opts.Projection.keyerFor
+	}
+
+	keyers := metricKeyersFor(expected, keyerFor)
+	expectedKeyMap := metricKeyMap(expected, keyers)
+	actualKeyMap := metricKeyMap(actual, keyers)
+	missing, extra := disjunct(expectedKeyMap, actualKeyMap)
+	return &Diff{
+		Missing: missing,
+		Extra:   extra,
+	}
+}
+
+func filterMetrics(metrics []*Metric, f *Filter) []*Metric {
+	var out []*Metric
+	for _, m := range metrics {
+		if f.Eval(m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Projection compiles to an ordered set of keyer builders, selecting which
+// fields and tags of a metric participate in DiffMetricsWithOpts matching.
+type Projection struct {
+	fields []astNode
+}
+
+// CompileProjection parses a projection expression such as
+// "name,tag:pod_name,tag:namespace_name" into a Projection.
+func CompileProjection(expr string) (*Projection, error) {
+	p := newParser(expr)
+	var fields []astNode
+	for {
+		node, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, node)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return &Projection{fields: fields}, nil
+}
+
+// keyerFor builds the keyer for expected metric m using only the fields and
+// tags named in the projection.
+func (p *Projection) keyerFor(m *Metric) keyer {
+	keyers := make([]keyer, 0, len(p.fields))
+	for _, node := range p.fields {
+		switch n := node.(type) {
+		case fieldNode:
+			switch n.name {
+			case "name":
+				keyers = append(keyers, nameKey(m.Name))
+			case "value":
+				keyers = append(keyers, valueKey(m.Value))
+			case "timestamp":
+				keyers = append(keyers, timestampKey(m.Timestamp))
+			}
+		case tagNode:
+			if v, ok := m.Tags[n.name]; ok {
+				keyers = append(keyers, fullTagKey(n.name, v))
+			} else {
+				keyers = append(keyers, tagNameKey(n.name))
+			}
+		}
+	}
+	return compositeKey(keyers...)
+}
+
+// Filter evaluates a boolean expression against a single metric, e.g.
+// `name=~"kube_pod_.*" AND tag:namespace_name!="kube-system"`.
+type Filter struct {
+	root astNode
+}
+
+// CompileFilter parses a filter expression into a Filter.
+func CompileFilter(expr string) (*Filter, error) {
+	p := newParser(expr)
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return &Filter{root: node}, nil
+}
+
+// Eval reports whether metric m satisfies the filter.
+func (f *Filter) Eval(m *Metric) bool {
+	return evalNode(f.root, m)
+}
+
+func evalNode(node astNode, m *Metric) bool {
+	switch n := node.(type) {
+	case andNode:
+		return evalNode(n.left, m) && evalNode(n.right, m)
+	case orNode:
+		return evalNode(n.left, m) || evalNode(n.right, m)
+	case notNode:
+		return !evalNode(n.node, m)
+	case cmpNode:
+		return evalCmp(n, m)
+	default:
+		panic(fmt.Sprintf("diff: unexpected ast node %T in filter", node))
+	}
+}
+
+func evalCmp(n cmpNode, m *Metric) bool {
+	actual := operandValue(n.operand, m)
+	switch n.op {
+	case "=":
+		return actual == n.value
+	case "!=":
+		return actual != n.value
+	case "=~":
+		return n.re.MatchString(actual)
+	case "!~":
+		return !n.re.MatchString(actual)
+	default:
+		panic(fmt.Sprintf("diff: unexpected comparison operator %q", n.op))
+	}
+}
+
+func operandValue(node astNode, m *Metric) string {
+	switch n := node.(type) {
+	case fieldNode:
+		switch n.name {
+		case "name":
+			return m.Name
+		case "value":
+			return m.Value
+		case "timestamp":
+			return m.Timestamp
+		}
+	case tagNode:
+		return m.Tags[n.name]
+	}
+	return ""
+}
+
+// astNode is implemented by fieldNode, tagNode, cmpNode, andNode, orNode and
+// notNode, the nodes produced by the projection/filter parser.
+type astNode interface{}
+
+type fieldNode struct{ name string }
+
+type tagNode struct{ name string }
+
+type cmpNode struct {
+	operand astNode
+	op      string
+	value   string
+	re      *regexp.Regexp // compiled once in parseCmp, set only for "=~" and "!~"
+}
+
+type andNode struct{ left, right astNode }
+
+type orNode struct{ left, right astNode }
+
+type notNode struct{ node astNode }
+
+// tokenKind enumerates the lexical tokens of the projection/filter language.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokComma
+	tokLParen
+	tokRParen
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// parser is a small recursive-descent parser shared by CompileProjection and
+// CompileFilter. Grammar:
+//
+//	proj   := operand (',' operand)*
+//	expr   := or
+//	or     := and ('OR' and)*
+//	and    := not ('AND' not)*
+//	not    := 'NOT' not | primary
+//	primary:= '(' or ')' | cmp
+//	cmp    := operand ('=' | '!=' | '=~' | '!~') string
+//	operand:= 'name' | 'value' | 'timestamp' | 'tag:' ident
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func newParser(expr string) *parser {
+	return &parser{tokens: tokenize(expr)}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expectEOF() error {
+	if p.peek().kind != tokEOF {
+		return fmt.Errorf("diff: unexpected token %q", p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (astNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (astNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (astNode, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node: node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (astNode, error) {
+	if p.peek().kind == tokLParen {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRParen {
+			return nil, fmt.Errorf("diff: expected ')', got %q", p.peek().text)
+		}
+		p.next()
+		return node, nil
+	}
+	return p.parseCmp()
+}
+
+func (p *parser) parseCmp() (astNode, error) {
+	operand, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	opTok := p.next()
+	if opTok.kind != tokOp {
+		return nil, fmt.Errorf("diff: expected comparison operator, got %q", opTok.text)
+	}
+	valTok := p.next()
+	if valTok.kind != tokString {
+		return nil, fmt.Errorf("diff: expected quoted string, got %q", valTok.text)
+	}
+	node := cmpNode{operand: operand, op: opTok.text, value: valTok.text}
+	if opTok.text == "=~" || opTok.text == "!~" {
+		re, err := regexp.Compile(valTok.text)
+		if err != nil {
+			return nil, fmt.Errorf("diff: invalid regexp %q: %w", valTok.text, err)
+		}
+		node.re = re
+	}
+	return node, nil
+}
+
+func (p *parser) parseOperand() (astNode, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return nil, fmt.Errorf("diff: expected field or tag name, got %q", tok.text)
+	}
+	if strings.HasPrefix(tok.text, "tag:") {
+		return tagNode{name: strings.TrimPrefix(tok.text, "tag:")}, nil
+	}
+	switch tok.text {
+	case "name", "value", "timestamp":
+		return fieldNode{name: tok.text}, nil
+	default:
+		return nil, fmt.Errorf("diff: unknown field %q (want name, value, timestamp or tag:<name>)", tok.text)
+	}
+}
+
+func tokenize(expr string) []token {
+	var tokens []token
+	runes := []rune(expr)
+	i := 0
+	for i < len(runes) {
+		c := runes[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ","})
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")"})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			raw := string(runes[i : j+1])
+			unquoted, err := strconv.Unquote(raw)
+			if err != nil {
+				unquoted = strings.Trim(raw, `"`)
+			}
+			tokens = append(tokens, token{kind: tokString, text: unquoted})
+			i = j + 1
+		case strings.HasPrefix(string(runes[i:]), "=~"):
+			tokens = append(tokens, token{kind: tokOp, text: "=~"})
+			i += 2
+		case strings.HasPrefix(string(runes[i:]), "!~"):
+			tokens = append(tokens, token{kind: tokOp, text: "!~"})
+			i += 2
+		case strings.HasPrefix(string(runes[i:]), "!="):
+			tokens = append(tokens, token{kind: tokOp, text: "!="})
+			i += 2
+		case c == '=':
+			tokens = append(tokens, token{kind: tokOp, text: "="})
+			i++
+		default:
+			j := i
+			for j < len(runes) && isIdentRune(runes[j]) {
+				j++
+			}
+			if j == i {
+				i++
+				continue
+			}
+			word := string(runes[i:j])
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, token{kind: tokAnd, text: word})
+			case "OR":
+				tokens = append(tokens, token{kind: tokOr, text: word})
+			case "NOT":
+				tokens = append(tokens, token{kind: tokNot, text: word})
+			default:
+				tokens = append(tokens, token{kind: tokIdent, text: word})
+			}
+			i = j
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF})
+	return tokens
+}
+
+func isIdentRune(r rune) bool {
+	return r == ':' || r == '_' || r == '.' || r == '-' ||
+		(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
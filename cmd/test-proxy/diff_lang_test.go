@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompileFilter_OperatorPrecedence(t *testing.T) {
+	// AND binds tighter than OR, and NOT binds tighter than AND, so this reads
+	// as (name="a" AND NOT tag:env="prod") OR name="b".
+	f, err := CompileFilter(`name="a" AND NOT tag:env="prod" OR name="b"`)
+	if err != nil {
+		t.Fatalf("CompileFilter: %v", err)
+	}
+	tests := []struct {
+		name string
+		m    *Metric
+		want bool
+	}{
+		{"first branch, env not prod", &Metric{Name: "a", Tags: map[string]string{"env": "dev"}}, true},
+		{"first branch, env prod", &Metric{Name: "a", Tags: map[string]string{"env": "prod"}}, false},
+		{"second branch matches on its own", &Metric{Name: "b", Tags: map[string]string{"env": "prod"}}, true},
+		{"neither branch", &Metric{Name: "c", Tags: map[string]string{"env": "prod"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Eval(tt.m); got != tt.want {
+				t.Errorf("Eval(%+v) = %v, want %v", tt.m, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFilter_Parens(t *testing.T) {
+	f, err := CompileFilter(`name="a" AND (tag:env="dev" OR tag:env="staging")`)
+	if err != nil {
+		t.Fatalf("CompileFilter: %v", err)
+	}
+	if !f.Eval(&Metric{Name: "a", Tags: map[string]string{"env": "staging"}}) {
+		t.Error("expected staging to match via the parenthesized OR")
+	}
+	if f.Eval(&Metric{Name: "a", Tags: map[string]string{"env": "prod"}}) {
+		t.Error("expected prod not to match")
+	}
+}
+
+func TestCompileFilter_Regex(t *testing.T) {
+	f, err := CompileFilter(`name=~"kube_pod_.*" AND tag:namespace_name!="kube-system"`)
+	if err != nil {
+		t.Fatalf("CompileFilter: %v", err)
+	}
+	match := &Metric{Name: "kube_pod_status_ready", Tags: map[string]string{"namespace_name": "default"}}
+	if !f.Eval(match) {
+		t.Errorf("expected %+v to match", match)
+	}
+	excludedByName := &Metric{Name: "kube_node_info", Tags: map[string]string{"namespace_name": "default"}}
+	if f.Eval(excludedByName) {
+		t.Errorf("expected %+v to be excluded by the name regex", excludedByName)
+	}
+	excludedByNamespace := &Metric{Name: "kube_pod_status_ready", Tags: map[string]string{"namespace_name": "kube-system"}}
+	if f.Eval(excludedByNamespace) {
+		t.Errorf("expected %+v to be excluded by the namespace filter", excludedByNamespace)
+	}
+}
+
+func TestCompileFilter_NotMatch(t *testing.T) {
+	f, err := CompileFilter(`NOT tag:phase="Running"`)
+	if err != nil {
+		t.Fatalf("CompileFilter: %v", err)
+	}
+	if f.Eval(&Metric{Tags: map[string]string{"phase": "Running"}}) {
+		t.Error("expected NOT to invert a matching comparison")
+	}
+	if !f.Eval(&Metric{Tags: map[string]string{"phase": "Pending"}}) {
+		t.Error("expected NOT to pass a non-matching comparison through")
+	}
+}
+
+func TestCompileFilter_MalformedInput(t *testing.T) {
+	tests := []string{
+		``,                   // empty expression
+		`name=`,              // missing value
+		`name == "a"`,        // unsupported operator
+		`name="a" AND`,       // dangling AND
+		`(name="a"`,          // unbalanced paren
+		`bogusfield="a"`,     // unknown field
+		`name=~"("`,          // invalid regexp
+		`name="a" tag:x="y"`, // missing connective between comparisons
+	}
+	for _, expr := range tests {
+		if _, err := CompileFilter(expr); err == nil {
+			t.Errorf("CompileFilter(%q): expected error, got nil", expr)
+		}
+	}
+}
+
+func TestCompileProjection(t *testing.T) {
+	p, err := CompileProjection("name,tag:pod_name,tag:namespace_name")
+	if err != nil {
+		t.Fatalf("CompileProjection: %v", err)
+	}
+	m := &Metric{
+		Name:      "kube_pod_status_ready",
+		Value:     "1",
+		Timestamp: "12345",
+		Tags:      map[string]string{"pod_name": "foo", "namespace_name": "default", "uid": "xyz"},
+	}
+	other := &Metric{
+		Name:      "kube_pod_status_ready",
+		Value:     "0",          // differs
+		Timestamp: "99999",      // differs
+		Tags:      map[string]string{"pod_name": "foo", "namespace_name": "default", "uid": "abc"}, // uid differs
+	}
+	k := p.keyerFor(m)
+	var bufA, bufB bytes.Buffer
+	if !k(&bufA, m) {
+		t.Fatalf("expected projection keyer to match %+v", m)
+	}
+	if !k(&bufB, other) {
+		t.Fatalf("expected projection keyer to ignore value/timestamp/uid and still match %+v", other)
+	}
+	if bufA.String() != bufB.String() {
+		t.Errorf("expected canonical bytes to be equal for metrics that only differ outside the projection; got %q vs %q", bufA.String(), bufB.String())
+	}
+}
+
+func TestCompileProjection_MalformedInput(t *testing.T) {
+	tests := []string{"", "bogus", "name,"}
+	for _, expr := range tests {
+		if _, err := CompileProjection(expr); err == nil {
+			t.Errorf("CompileProjection(%q): expected error, got nil", expr)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetricKeyMap_Dedup(t *testing.T) {
+	// Two metrics that key identically (same name/value/timestamp/tags) should
+	// collapse to one bucket, with the later metric winning.
+	first := &Metric{Name: "kube_pod_status_ready", Value: "1", Timestamp: "1", Tags: map[string]string{"pod_name": "a"}}
+	second := &Metric{Name: "kube_pod_status_ready", Value: "1", Timestamp: "1", Tags: map[string]string{"pod_name": "a"}}
+	keyers := metricKeyers([]*Metric{first})
+	buckets := metricKeyMap([]*Metric{first, second}, keyers)
+	if len(buckets) != 1 {
+		t.Fatalf("expected metrics with identical keys to collapse to 1 bucket, got %d", len(buckets))
+	}
+	for _, list := range buckets {
+		if len(list) != 1 {
+			t.Fatalf("expected exactly one keyBucket per sum, got %d", len(list))
+		}
+		if list[0].metric != second {
+			t.Errorf("expected the later metric to win the bucket")
+		}
+	}
+}
+
+// TestFindCanon_HashCollision exercises the cold-path equality check that
+// disjunct/metricKeyMap fall back on when two distinct keys hash to the same
+// maphash sum. Engineering a genuine 64-bit maphash collision isn't practical
+// in a unit test, so this constructs the colliding bucket list directly,
+// which is the same shape metricKeyMap would produce if two distinct
+// canonical byte strings happened to hash identically.
+func TestFindCanon_HashCollision(t *testing.T) {
+	metricA := &Metric{Name: "a"}
+	metricB := &Metric{Name: "b"}
+	canonA := []byte("canon-a")
+	canonB := []byte("canon-b")
+	buckets := []*keyBucket{
+		{metric: metricA, canon: canonA},
+		{metric: metricB, canon: canonB},
+	}
+
+	if got := findCanon(buckets, canonA); got == nil || got.metric != metricA {
+		t.Errorf("findCanon(canonA) = %v, want the bucket for metricA", got)
+	}
+	if got := findCanon(buckets, canonB); got == nil || got.metric != metricB {
+		t.Errorf("findCanon(canonB) = %v, want the bucket for metricB", got)
+	}
+	if got := findCanon(buckets, []byte("canon-c")); got != nil {
+		t.Errorf("findCanon(unknown canon) = %v, want nil", got)
+	}
+}
+
+func TestDisjunct_HashCollision(t *testing.T) {
+	// Simulate two metrics on either side of the diff that collide into the
+	// same sum (sum 7) despite being genuinely different keys, alongside a
+	// metric present on both sides under that same sum.
+	expectedOnly := &Metric{Name: "expected-only"}
+	actualOnly := &Metric{Name: "actual-only"}
+	shared := &Metric{Name: "shared"}
+
+	expected := map[uint64][]*keyBucket{
+		7: {{metric: expectedOnly, canon: []byte("canon-expected")}, {metric: shared, canon: []byte("canon-shared")}},
+	}
+	actual := map[uint64][]*keyBucket{
+		7: {{metric: actualOnly, canon: []byte("canon-actual")}, {metric: shared, canon: []byte("canon-shared")}},
+	}
+
+	missing, extra := disjunct(expected, actual)
+	if len(missing) != 1 || missing[0] != expectedOnly {
+		t.Errorf("missing = %v, want [%v]", missing, expectedOnly)
+	}
+	if len(extra) != 1 || extra[0] != actualOnly {
+		t.Errorf("extra = %v, want [%v]", extra, actualOnly)
+	}
+}
+
+func TestTagsKey_OrderIndependent(t *testing.T) {
+	a := &Metric{Tags: map[string]string{"pod_name": "foo", "namespace_name": "default"}}
+	b := &Metric{Tags: map[string]string{"namespace_name": "default", "pod_name": "foo"}}
+
+	var bufA, bufB bytes.Buffer
+	if !tagsKey(a.Tags)(&bufA, a) {
+		t.Fatalf("expected tagsKey to match a against itself")
+	}
+	if !tagsKey(b.Tags)(&bufB, b) {
+		t.Fatalf("expected tagsKey to match b against itself")
+	}
+	if bufA.String() != bufB.String() {
+		t.Errorf("expected tagsKey's canonical bytes to be independent of map iteration order; got %q vs %q", bufA.String(), bufB.String())
+	}
+}
+
+func TestTagsKey_PresenceOnlyMatch(t *testing.T) {
+	// An empty tag value in the expected metric means "tag must be present,
+	// value doesn't matter" (tagNameKey), rather than "tag must equal the
+	// empty string".
+	expected := &Metric{Tags: map[string]string{"pod_name": ""}}
+	k := tagsKey(expected.Tags)
+
+	present := &Metric{Tags: map[string]string{"pod_name": "foo"}}
+	var buf bytes.Buffer
+	if !k(&buf, present) {
+		t.Error("expected presence-only key to match any value for pod_name")
+	}
+
+	absent := &Metric{Tags: map[string]string{}}
+	var buf2 bytes.Buffer
+	if k(&buf2, absent) {
+		t.Error("expected presence-only key to reject a metric missing the tag entirely")
+	}
+}
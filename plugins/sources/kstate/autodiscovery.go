@@ -0,0 +1,296 @@
+package kstate
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/wavefronthq/wavefront-kubernetes-collector/internal/metrics"
+)
+
+// KStateAutodiscoveryConfig allowlists CRD group/kinds that should get
+// generic kstate-style metrics without a hand-written builder. Group and
+// Kind are shell globs (as accepted by path/filepath.Match), so "*.istio.io"
+// or "Workflow" both work; an empty Resources list disables autodiscovery.
+type KStateAutodiscoveryConfig struct {
+	Resources []ResourceRule `yaml:"resources"`
+}
+
+// ResourceRule describes how to turn instances of a matching CRD into
+// metrics: which numeric fields to extract, which status conditions to turn
+// into gauges, and which labels/annotations to carry over as tags.
+type ResourceRule struct {
+	Group       string          `yaml:"group"`
+	Kind        string          `yaml:"kind"`
+	Metrics     []MetricRule    `yaml:"metrics,omitempty"`
+	Conditions  []ConditionRule `yaml:"conditions,omitempty"`
+	Labels      []string        `yaml:"labels,omitempty"`
+	Annotations []string        `yaml:"annotations,omitempty"`
+}
+
+// MetricRule extracts a numeric field at Path (dot-separated, e.g.
+// "status.readyReplicas") and emits it as kubernetes.<kind>.<Name>.
+type MetricRule struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+// ConditionRule maps a status.conditions[] entry whose type equals Type to a
+// 1/0 gauge named kubernetes.<kind>.<MetricName>, mirroring how the builtin
+// pod condition builder turns "Ready"/"True" into a gauge.
+type ConditionRule struct {
+	Type       string `yaml:"type"`
+	MetricName string `yaml:"metricName"`
+}
+
+// Autodiscovery watches the API server's discovery endpoint for CRDs whose
+// GroupVersionKind matches the configured allowlist, registers a dynamic
+// informer for each one it finds, and reports points for every add/update
+// through sink using the same buildTags/metricPoint helpers the builtin
+// kstate builders use.
+type Autodiscovery struct {
+	disco     discovery.DiscoveryInterface
+	cfg       KStateAutodiscoveryConfig
+	prefix    string
+	sink      func([]*metrics.MetricPoint)
+	collector CollectorIdentity
+	stats     *Stats
+	factory   dynamicinformer.DynamicSharedInformerFactory
+
+	mu        sync.Mutex
+	informers map[schema.GroupVersionResource]cache.SharedIndexInformer
+	stopCh    chan struct{}
+}
+
+// NewAutodiscovery builds an Autodiscovery backed by a single shared
+// informer factory over dyn, resynced every resync interval. alias, when
+// set, is stamped as the collector_instance tag on every point and self-stat
+// this Autodiscovery emits, so it can be told apart from other kstate
+// collector instances.
+func NewAutodiscovery(disco discovery.DiscoveryInterface, dyn dynamic.Interface, prefix string, cfg KStateAutodiscoveryConfig, alias string, resync time.Duration, sink func([]*metrics.MetricPoint)) *Autodiscovery {
+	collector := CollectorIdentity{Alias: alias}
+	return &Autodiscovery{
+		disco:     disco,
+		cfg:       cfg,
+		prefix:    prefix,
+		sink:      sink,
+		collector: collector,
+		stats:     NewStats(collector),
+		factory:   dynamicinformer.NewDynamicSharedInformerFactory(dyn, resync),
+		informers: make(map[schema.GroupVersionResource]cache.SharedIndexInformer),
+	}
+}
+
+// StatPoints snapshots this Autodiscovery's self-stats, for the kstate
+// source to fold in alongside its regular scrape points.
+func (a *Autodiscovery) StatPoints(ts int64) []*metrics.MetricPoint {
+	return a.stats.Points(ts)
+}
+
+// Start registers informers for every currently matching resource, waits for
+// their caches to sync before returning, and
+// keeps re-polling discovery every pollInterval to pick up CRDs installed
+// after startup. Call Stop to tear everything down.
+func (a *Autodiscovery) Start(pollInterval time.Duration) error {
+	a.stopCh = make(chan struct{})
+	a.discover()
+	if !a.waitForCacheSync() {
+		return fmt.Errorf("kstate: autodiscovery informer caches did not sync")
+	}
+	go a.watchDiscovery(pollInterval)
+	return nil
+}
+
+func (a *Autodiscovery) Stop() {
+	if a.stopCh != nil {
+		close(a.stopCh)
+	}
+}
+
+func (a *Autodiscovery) watchDiscovery(pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case <-ticker.C:
+			a.discover()
+			a.waitForCacheSync()
+		}
+	}
+}
+
+func (a *Autodiscovery) discover() {
+	// ServerGroupsAndResources routinely returns a non-nil
+	// *discovery.ErrGroupDiscoveryFailed alongside partial results whenever
+	// some aggregated/CRD apiservice is temporarily unavailable; still
+	// register informers for whatever it did return instead of discarding
+	// the whole refresh.
+	_, apiResourceLists, err := a.disco.ServerGroupsAndResources()
+	if err != nil {
+		logrus.Errorf("kstate: listing discovery resources: %v", err)
+	}
+	for _, list := range apiResourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, res := range list.APIResources {
+			rule, ok := a.ruleFor(gv.Group, res.Kind)
+			if !ok {
+				continue
+			}
+			a.ensureInformer(gv.WithResource(res.Name), res.Kind, rule)
+		}
+	}
+	a.factory.Start(a.stopCh)
+}
+
+// waitForCacheSync blocks until every informer registered so far has synced.
+func (a *Autodiscovery) waitForCacheSync() bool {
+	a.mu.Lock()
+	syncFuncs := make([]cache.InformerSynced, 0, len(a.informers))
+	for _, informer := range a.informers {
+		syncFuncs = append(syncFuncs, informer.HasSynced)
+	}
+	a.mu.Unlock()
+	return cache.WaitForCacheSync(a.stopCh, syncFuncs...)
+}
+
+func (a *Autodiscovery) ruleFor(group, kind string) (ResourceRule, bool) {
+	for _, rule := range a.cfg.Resources {
+		if globMatch(rule.Group, group) && globMatch(rule.Kind, kind) {
+			return rule, true
+		}
+	}
+	return ResourceRule{}, false
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	ok, err := filepath.Match(pattern, value)
+	return err == nil && ok
+}
+
+// ensureInformer registers gvr with the shared factory the first time it's
+// seen. The informer doesn't actually start running until the next
+// a.factory.Start call in discover; Start/watchDiscovery then wait for its
+// cache to sync, but its event handlers, and so sink, can already be called
+// for objects of the initial list before that wait returns.
+func (a *Autodiscovery) ensureInformer(gvr schema.GroupVersionResource, kind string, rule ResourceRule) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := a.informers[gvr]; exists {
+		return
+	}
+	informer := a.factory.ForResource(gvr).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { a.emit(obj, kind, rule) },
+		UpdateFunc: func(_, obj interface{}) { a.emit(obj, kind, rule) },
+	})
+	a.informers[gvr] = informer
+}
+
+func (a *Autodiscovery) emit(obj interface{}, kind string, rule ResourceRule) {
+	builder := strings.ToLower(kind)
+	start := time.Now()
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		a.stats.Error(builder)
+		return
+	}
+
+	tags := buildTags(builder+"_name", u.GetName(), u.GetNamespace(), extraTags(u, rule))
+	now := time.Now().Unix()
+
+	var points []*metrics.MetricPoint
+	for _, mr := range rule.Metrics {
+		if val, ok := numericField(u, mr.Path); ok {
+			points = append(points, metricPoint(a.prefix+builder+".", mr.Name, val, now, u.GetName(), tags, builder, a.collector))
+		}
+	}
+	for _, cr := range rule.Conditions {
+		if val, ok := conditionValue(u, cr.Type); ok {
+			points = append(points, metricPoint(a.prefix+builder+".", cr.MetricName, val, now, u.GetName(), tags, builder, a.collector))
+		}
+	}
+	if len(points) == 0 {
+		return
+	}
+	if a.sink != nil {
+		a.sink(points)
+	}
+	a.stats.Observe(builder, len(points), time.Since(start))
+}
+
+func extraTags(u *unstructured.Unstructured, rule ResourceRule) map[string]string {
+	tags := make(map[string]string, len(rule.Labels)+len(rule.Annotations))
+	labels := u.GetLabels()
+	for _, name := range rule.Labels {
+		if v, ok := labels[name]; ok {
+			tags["label_"+name] = v
+		}
+	}
+	annotations := u.GetAnnotations()
+	for _, name := range rule.Annotations {
+		if v, ok := annotations[name]; ok {
+			tags["annotation_"+name] = v
+		}
+	}
+	return tags
+}
+
+// numericField resolves a dot-separated path (e.g. "status.readyReplicas")
+// against obj's fields. It covers the numeric-ish shapes unstructured
+// objects actually decode to (float64, int64, or a numeric string) rather
+// than a full JSONPath implementation.
+func numericField(obj *unstructured.Unstructured, path string) (float64, bool) {
+	fields := strings.Split(path, ".")
+	if v, found, err := unstructured.NestedFloat64(obj.Object, fields...); err == nil && found {
+		return v, true
+	}
+	if v, found, err := unstructured.NestedInt64(obj.Object, fields...); err == nil && found {
+		return float64(v), true
+	}
+	if s, found, err := unstructured.NestedString(obj.Object, fields...); err == nil && found {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// conditionValue reports the gauge value (1 for "True", 0 otherwise) of the
+// first status.conditions[] entry whose type matches condType.
+func conditionValue(obj *unstructured.Unstructured, condType string) (float64, bool) {
+	conditions, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil || !found {
+		return 0, false
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != condType {
+			continue
+		}
+		if condition["status"] == "True" {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,194 @@
+package kstate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/wavefronthq/wavefront-kubernetes-collector/internal/metrics"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		name, pattern, value string
+		want                 bool
+	}{
+		{"empty pattern matches anything", "", "Workflow", true},
+		{"star matches anything", "*", "argoproj.io", true},
+		{"exact match", "Workflow", "Workflow", true},
+		{"exact mismatch", "Workflow", "Rollout", false},
+		{"suffix glob match", "*.istio.io", "networking.istio.io", true},
+		{"suffix glob mismatch", "*.istio.io", "istio.io", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := globMatch(tt.pattern, tt.value); got != tt.want {
+				t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutodiscovery_RuleFor(t *testing.T) {
+	workflowRule := ResourceRule{Group: "argoproj.io", Kind: "Workflow"}
+	anyIstioRule := ResourceRule{Group: "*.istio.io", Kind: "*"}
+	a := &Autodiscovery{cfg: KStateAutodiscoveryConfig{Resources: []ResourceRule{workflowRule, anyIstioRule}}}
+
+	tests := []struct {
+		name        string
+		group, kind string
+		wantRule    ResourceRule
+		wantOK      bool
+	}{
+		{"matches exact group/kind", "argoproj.io", "Workflow", workflowRule, true},
+		{"matches glob group, any kind", "networking.istio.io", "VirtualService", anyIstioRule, true},
+		{"no matching rule", "apps", "Deployment", ResourceRule{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := a.ruleFor(tt.group, tt.kind)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.wantRule) {
+				t.Errorf("ruleFor(%q, %q) = (%+v, %v), want (%+v, %v)", tt.group, tt.kind, got, ok, tt.wantRule, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNumericField(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"readyReplicas": int64(3),
+			"ratio":         0.5,
+			"asString":      "7",
+			"notANumber":    "abc",
+		},
+	}}
+	tests := []struct {
+		name   string
+		path   string
+		want   float64
+		wantOK bool
+	}{
+		{"int64 field", "status.readyReplicas", 3, true},
+		{"float64 field", "status.ratio", 0.5, true},
+		{"numeric string field", "status.asString", 7, true},
+		{"non-numeric string field", "status.notANumber", 0, false},
+		{"missing field", "status.missing", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := numericField(obj, tt.path)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("numericField(%q) = (%v, %v), want (%v, %v)", tt.path, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestConditionValue(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+				map[string]interface{}{"type": "Degraded", "status": "False"},
+			},
+		},
+	}}
+	tests := []struct {
+		name     string
+		condType string
+		want     float64
+		wantOK   bool
+	}{
+		{"true condition", "Ready", 1, true},
+		{"false condition", "Degraded", 0, true},
+		{"absent condition", "Unknown", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := conditionValue(obj, tt.condType)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("conditionValue(%q) = (%v, %v), want (%v, %v)", tt.condType, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestAutodiscovery_StartDiscoverEmit drives a real Autodiscovery against
+// fake discovery/dynamic clientsets: one matching CRD is pre-populated, and
+// Start should discover it, sync its informer, and emit a point for it
+// through sink with the configured prefix and self-instrumentation tags.
+func TestAutodiscovery_StartDiscoverEmit(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "workflows"}
+	gvk := schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Workflow"}
+
+	workflow := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": gvk.GroupVersion().String(),
+		"kind":       gvk.Kind,
+		"metadata": map[string]interface{}{
+			"name":      "my-workflow",
+			"namespace": "default",
+		},
+		"status": map[string]interface{}{
+			"progress": int64(42),
+		},
+	}}
+
+	scheme := runtime.NewScheme()
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{gvr: "WorkflowList"}, workflow)
+
+	disco, ok := kubefake.NewSimpleClientset().Discovery().(*discoveryfake.FakeDiscovery)
+	if !ok {
+		t.Fatal("expected fake discovery client")
+	}
+	disco.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: gvk.GroupVersion().String(),
+			APIResources: []metav1.APIResource{
+				{Name: gvr.Resource, Kind: gvk.Kind, Namespaced: true},
+			},
+		},
+	}
+
+	var points []*metrics.MetricPoint
+	sink := func(p []*metrics.MetricPoint) { points = append(points, p...) }
+
+	cfg := KStateAutodiscoveryConfig{Resources: []ResourceRule{
+		{Group: "argoproj.io", Kind: "Workflow", Metrics: []MetricRule{{Name: "progress", Path: "status.progress"}}},
+	}}
+	a := NewAutodiscovery(disco, dyn, "kubernetes.", cfg, "shard-1", time.Minute, sink)
+	if err := a.Start(time.Minute); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer a.Stop()
+
+	// The informer's initial list delivers the pre-populated workflow via
+	// AddFunc asynchronously; poll briefly rather than racing the event loop.
+	deadline := time.Now().Add(time.Second)
+	for len(points) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if len(points) != 1 {
+		t.Fatalf("got %d points, want 1", len(points))
+	}
+	p := points[0]
+	if p.Metric != "kubernetes.workflow.progress" {
+		t.Errorf("Metric = %q, want %q", p.Metric, "kubernetes.workflow.progress")
+	}
+	if p.Value != 42 {
+		t.Errorf("Value = %v, want 42", p.Value)
+	}
+	if p.Tags["collector"] != "kstate" || p.Tags["builder"] != "workflow" || p.Tags["collector_instance"] != "shard-1" {
+		t.Errorf("unexpected self-instrumentation tags: %+v", p.Tags)
+	}
+}
@@ -2,6 +2,24 @@ package kstate
 
 import "github.com/wavefronthq/wavefront-kubernetes-collector/internal/metrics"
 
+// CollectorIdentity identifies the kstate collector instance emitting a
+// point, so operators running several kstate collectors (e.g. sharded
+// across large clusters) can attribute points and error rates back to a
+// specific one. Alias comes from the collector config; when it is unset,
+// collector_instance is omitted rather than stamped as "", to avoid the
+// cardinality explosion an empty-string tag value would cause.
+type CollectorIdentity struct {
+	Alias string
+}
+
+func (c CollectorIdentity) stamp(tags map[string]string, builder string) {
+	tags["collector"] = "kstate"
+	tags["builder"] = builder
+	if c.Alias != "" {
+		tags["collector_instance"] = c.Alias
+	}
+}
+
 func buildTags(key, name, ns string, srcTags map[string]string) map[string]string {
 	tags := make(map[string]string, len(srcTags)+2)
 	tags[key] = name
@@ -12,7 +30,15 @@ func buildTags(key, name, ns string, srcTags map[string]string) map[string]strin
 	return tags
 }
 
-func metricPoint(prefix, name string, value float64, ts int64, source string, tags map[string]string) *metrics.MetricPoint {
+// metricPoint stamps collector/builder self-instrumentation tags onto tags
+// and builds the point. Stamping lives here, rather than in buildTags,
+// because metricPoint is the one chokepoint every kstate metric point flows
+// through on its way out (this package doesn't yet have per-builtin-kind
+// builder functions of its own to update individually); putting it here
+// means every kstate metricPoint gets collector=kstate, builder=<kind> and,
+// when alias is set, collector_instance=<alias>, not just autodiscovery's.
+func metricPoint(prefix, name string, value float64, ts int64, source string, tags map[string]string, builder string, collector CollectorIdentity) *metrics.MetricPoint {
+	collector.stamp(tags, builder)
 	return &metrics.MetricPoint{
 		Metric:    prefix + name,
 		Value:     value,
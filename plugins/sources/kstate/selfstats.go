@@ -0,0 +1,80 @@
+package kstate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/wavefronthq/wavefront-kubernetes-collector/internal/metrics"
+)
+
+// Stats tracks per-builder point counts, error counts and last-observed
+// latency for self-instrumentation, published as
+// ~collector.kstate.<builder>.points_emitted/.errors/.latency_ns and tagged
+// with the same CollectorIdentity as the points they describe.
+type Stats struct {
+	collector CollectorIdentity
+
+	mu       sync.Mutex
+	builders map[string]*builderCounts
+}
+
+type builderCounts struct {
+	emitted   int64
+	errors    int64
+	latencyNs int64
+}
+
+func NewStats(collector CollectorIdentity) *Stats {
+	return &Stats{collector: collector, builders: make(map[string]*builderCounts)}
+}
+
+// Observe records that builder emitted n points in latency.
+func (s *Stats) Observe(builder string, n int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.countsLocked(builder)
+	c.emitted += int64(n)
+	c.latencyNs = latency.Nanoseconds()
+}
+
+// Error records a single failed emission attempt for builder.
+func (s *Stats) Error(builder string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.countsLocked(builder).errors++
+}
+
+func (s *Stats) countsLocked(builder string) *builderCounts {
+	c, ok := s.builders[builder]
+	if !ok {
+		c = &builderCounts{}
+		s.builders[builder] = c
+	}
+	return c
+}
+
+// Points snapshots the current counters as self-stat metric points.
+func (s *Stats) Points(ts int64) []*metrics.MetricPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	points := make([]*metrics.MetricPoint, 0, len(s.builders)*3)
+	for builder, c := range s.builders {
+		tags := map[string]string{}
+		s.collector.stamp(tags, builder)
+		points = append(points,
+			selfStat(builder, "points_emitted", float64(c.emitted), ts, tags),
+			selfStat(builder, "errors", float64(c.errors), ts, tags),
+			selfStat(builder, "latency_ns", float64(c.latencyNs), ts, tags),
+		)
+	}
+	return points
+}
+
+func selfStat(builder, name string, value float64, ts int64, tags map[string]string) *metrics.MetricPoint {
+	return &metrics.MetricPoint{
+		Metric:    "~collector.kstate." + builder + "." + name,
+		Value:     value,
+		Timestamp: ts,
+		Tags:      tags,
+	}
+}
@@ -0,0 +1,54 @@
+package kstate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats_ObserveErrorPoints(t *testing.T) {
+	s := NewStats(CollectorIdentity{Alias: "shard-1"})
+	s.Observe("pod", 3, 150*time.Millisecond)
+	s.Observe("pod", 2, 50*time.Millisecond)
+	s.Error("pod")
+	s.Error("deployment")
+
+	want := map[string]float64{
+		"~collector.kstate.pod.points_emitted":        5,
+		"~collector.kstate.pod.errors":                1,
+		"~collector.kstate.pod.latency_ns":            float64((50 * time.Millisecond).Nanoseconds()),
+		"~collector.kstate.deployment.points_emitted": 0,
+		"~collector.kstate.deployment.errors":         1,
+		"~collector.kstate.deployment.latency_ns":     0,
+	}
+
+	points := s.Points(1000)
+	if len(points) != len(want) {
+		t.Fatalf("got %d points, want %d", len(points), len(want))
+	}
+	for _, p := range points {
+		wantVal, ok := want[p.Metric]
+		if !ok {
+			t.Errorf("unexpected metric %q", p.Metric)
+			continue
+		}
+		if p.Value != wantVal {
+			t.Errorf("%s = %v, want %v", p.Metric, p.Value, wantVal)
+		}
+		if p.Timestamp != 1000 {
+			t.Errorf("%s Timestamp = %v, want 1000", p.Metric, p.Timestamp)
+		}
+		if p.Tags["collector_instance"] != "shard-1" {
+			t.Errorf("%s: missing collector_instance tag, got %+v", p.Metric, p.Tags)
+		}
+	}
+}
+
+func TestStats_NoAliasOmitsTag(t *testing.T) {
+	s := NewStats(CollectorIdentity{})
+	s.Observe("pod", 1, time.Millisecond)
+	for _, p := range s.Points(0) {
+		if _, ok := p.Tags["collector_instance"]; ok {
+			t.Errorf("%s: expected no collector_instance tag when alias is unset, got %q", p.Metric, p.Tags["collector_instance"])
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package kstate
+
+import (
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/wavefronthq/wavefront-kubernetes-collector/internal/metrics"
+)
+
+// Config is the kstate source's user-facing configuration. Alias, when set,
+// is stamped as the collector_instance tag on every point and self-stat this
+// source emits. Autodiscovery is left at its zero value (no Resources) by
+// default, which disables it entirely.
+type Config struct {
+	Alias         string                   `yaml:"alias,omitempty"`
+	Autodiscovery KStateAutodiscoveryConfig `yaml:"autodiscovery,omitempty"`
+
+	// Resync is how often autodiscovery informers do a full relist; PollInterval
+	// is how often the discovery endpoint is re-scanned for newly installed
+	// CRDs. Both default to 5 minutes when unset.
+	Resync       time.Duration `yaml:"resyncInterval,omitempty"`
+	PollInterval time.Duration `yaml:"pollInterval,omitempty"`
+}
+
+const defaultInterval = 5 * time.Minute
+
+// Source is the kstate source's runtime. It runs CRD autodiscovery when
+// configured; it holds no builtin-kind builders. Callers construct one with NewSource and
+// call Start once the source is ready to begin emitting points.
+type Source struct {
+	cfg           Config
+	autodiscovery *Autodiscovery
+}
+
+// NewSource builds a kstate Source from cfg. disco and dyn are only used when
+// cfg.Autodiscovery declares at least one resource rule; sink receives every
+// point autodiscovery emits.
+func NewSource(disco discovery.DiscoveryInterface, dyn dynamic.Interface, prefix string, cfg Config, sink func([]*metrics.MetricPoint)) *Source {
+	s := &Source{cfg: cfg}
+	if len(cfg.Autodiscovery.Resources) == 0 {
+		return s
+	}
+	resync := cfg.Resync
+	if resync == 0 {
+		resync = defaultInterval
+	}
+	s.autodiscovery = NewAutodiscovery(disco, dyn, prefix, cfg.Autodiscovery, cfg.Alias, resync, sink)
+	return s
+}
+
+// Start brings up CRD autodiscovery, if configured. It is a no-op when the
+// source has no autodiscovery resource rules.
+func (s *Source) Start() error {
+	if s.autodiscovery == nil {
+		return nil
+	}
+	pollInterval := s.cfg.PollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultInterval
+	}
+	return s.autodiscovery.Start(pollInterval)
+}
+
+// Stop tears down CRD autodiscovery, if it was started.
+func (s *Source) Stop() {
+	if s.autodiscovery != nil {
+		s.autodiscovery.Stop()
+	}
+}
+
+// StatPoints snapshots this source's self-stats, including autodiscovery's
+// when configured.
+func (s *Source) StatPoints(ts int64) []*metrics.MetricPoint {
+	if s.autodiscovery == nil {
+		return nil
+	}
+	return s.autodiscovery.StatPoints(ts)
+}
@@ -0,0 +1,14 @@
+package kstate
+
+import "testing"
+
+func TestNewSource_NoAutodiscoveryConfigured(t *testing.T) {
+	s := NewSource(nil, nil, "kubernetes.", Config{}, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer s.Stop()
+	if got := s.StatPoints(0); got != nil {
+		t.Errorf("StatPoints = %v, want nil when autodiscovery isn't configured", got)
+	}
+}